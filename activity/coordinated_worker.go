@@ -1,6 +1,7 @@
 package activity
 
 import (
+	"context"
 	"log"
 	"time"
 
@@ -45,37 +46,58 @@ type coordinatedActivityAdapter struct {
 	handler           *CoordinatedActivityHandler
 }
 
-func (c *coordinatedActivityAdapter) heartbeat(activityTask *swf.PollForActivityTaskOutput, stop <-chan struct{}, cancelActivity chan error) {
-	heartbeats := time.NewTicker(c.heartbeatInterval)
-	defer heartbeats.Stop()
+// heartbeat sends heartbeats for activityTask until ctx is cancelled or stop is closed. On
+// cancellation-requested or task-gone, it records the reason on ctx's dispatchCancel instead of
+// returning an error, so dispatch classifies the eventual coordinate() response (fail/cancel/drop) the
+// same way it would for a plain auto-heartbeat handler.
+func (c *coordinatedActivityAdapter) heartbeat(ctx context.Context, activityTask *swf.PollForActivityTaskOutput, stop <-chan struct{}) {
+	scheduler := c.heartbeatScheduler()
+	timer := time.NewTimer(scheduler.Next())
+	defer timer.Stop()
 	for {
 		select {
-		case <-heartbeats.C:
+		case <-ctx.Done():
+			return
+		case <-timer.C:
 			if status, err := c.worker.SWF.RecordActivityTaskHeartbeat(&swf.RecordActivityTaskHeartbeatInput{
 				TaskToken: activityTask.TaskToken,
+				Details:   progressReporterFrom(ctx).takeDetails(),
 			}); err != nil {
 				if ae, ok := err.(awserr.Error); ok && ae.Code() == ErrorTypeUnknownResourceFault && strings.Contains(ae.Message(), TaskGone) {
 					log.Printf("workflow-id=%s activity-id=%s activity-id=%s at=activity-gone", LS(activityTask.WorkflowExecution.WorkflowID), LS(activityTask.ActivityType.Name), LS(activityTask.ActivityID))
-					cancelActivity <- nil
+					dispatchCancelFrom(ctx).cancelWith(cancelReasonTaskGone)
 					return
 				}
 				log.Printf("workflow-id=%s activity-id=%s activity-id=%s at=heartbeat-error error=%s ", LS(activityTask.WorkflowExecution.WorkflowID), LS(activityTask.ActivityType.Name), LS(activityTask.ActivityID), err.Error())
+				scheduler.OnError(err)
 			} else {
 				log.Printf("workflow-id=%s activity-id=%s activity-id=%s at=heartbeat-recorded", LS(activityTask.WorkflowExecution.WorkflowID), LS(activityTask.ActivityType.Name), LS(activityTask.ActivityID))
+				scheduler.OnSuccess()
 				if *status.CancelRequested {
 					log.Printf("workflow-id=%s activity-id=%s activity-id=%s at=activity-cancel-requested", LS(activityTask.WorkflowExecution.WorkflowID), LS(activityTask.ActivityType.Name), LS(activityTask.ActivityID))
-					cancelActivity <- ActivityTaskCanceledError{}
+					dispatchCancelFrom(ctx).cancelWith(cancelReasonCancelRequested)
 					return
 				}
 			}
+			timer.Reset(scheduler.Next())
 		case <-stop:
 			return
 		}
 	}
 }
 
-func (c *coordinatedActivityAdapter) coordinate(activityTask *swf.PollForActivityTaskOutput, input interface{}) (interface{}, error) {
-	update, err := c.handler.Start(activityTask, input)
+// heartbeatScheduler builds a fresh HeartbeatScheduler for a single task's run, via the handler's
+// configured Scheduler factory, or the default jittered, backed-off one built from
+// heartbeatInterval. It must be called once per task, never shared across concurrent tasks.
+func (c *coordinatedActivityAdapter) heartbeatScheduler() HeartbeatScheduler {
+	if c.handler.Scheduler != nil {
+		return c.handler.Scheduler()
+	}
+	return NewHeartbeatScheduler(c.heartbeatInterval, c.heartbeatInterval*8)
+}
+
+func (c *coordinatedActivityAdapter) coordinate(ctx context.Context, activityTask *swf.PollForActivityTaskOutput, input interface{}) (interface{}, error) {
+	update, err := c.handler.Start(ctx, activityTask, input)
 	if err != nil {
 		return nil, err
 	}
@@ -83,23 +105,31 @@ func (c *coordinatedActivityAdapter) coordinate(activityTask *swf.PollForActivit
 		return nil, err
 	}
 
+	// cancel carries errors that should cut the run short for reasons internal to this loop (e.g. a
+	// failed signalUpdate); heartbeat-driven cancellation (cancel-requested, task-gone) instead
+	// cancels ctx directly via dispatchCancelFrom, which the ctx.Done() case below picks up.
 	cancel := make(chan error)
 	stopHeartbeating := make(chan struct{})
 
-	go c.heartbeat(activityTask, stopHeartbeating, cancel)
+	go c.heartbeat(ctx, activityTask, stopHeartbeating)
 	defer close(stopHeartbeating)
 
 	ticks := time.NewTicker(c.tickMinInterval)
 	defer ticks.Stop()
 	for {
 		select {
+		case <-ctx.Done():
+			if err := c.handler.Cancel(ctx, activityTask, input); err != nil {
+				log.Printf("workflow-id=%s activity-id=%s activity-id=%s at=activity-cancel-err err=%q", LS(activityTask.WorkflowExecution.WorkflowID), LS(activityTask.ActivityType.Name), LS(activityTask.ActivityID), err)
+			}
+			return nil, ctx.Err()
 		case cause := <-cancel:
-			if err := c.handler.Cancel(activityTask, input); err != nil {
+			if err := c.handler.Cancel(ctx, activityTask, input); err != nil {
 				log.Printf("workflow-id=%s activity-id=%s activity-id=%s at=activity-cancel-err err=%q", LS(activityTask.WorkflowExecution.WorkflowID), LS(activityTask.ActivityType.Name), LS(activityTask.ActivityID), err)
 			}
 			return nil, cause
 		case <-ticks.C:
-			cont, res, err := c.handler.Tick(activityTask, input)
+			cont, res, err := c.handler.Tick(ctx, activityTask, input)
 			if !cont {
 				return res, err
 			}
@@ -0,0 +1,199 @@
+package activity
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/swf"
+	. "github.com/sclasen/swfsm/sugar"
+)
+
+// fakeAWSError implements awserr.Error with just the fields the worker package inspects.
+type fakeAWSError struct {
+	code    string
+	message string
+}
+
+func (e *fakeAWSError) Error() string   { return e.code + ": " + e.message }
+func (e *fakeAWSError) Code() string    { return e.code }
+func (e *fakeAWSError) Message() string { return e.message }
+func (e *fakeAWSError) OrigErr() error  { return nil }
+
+// coordinatedFakeSWFClient is a fakeSWFClient whose RecordActivityTaskHeartbeat response is driven by
+// a test-supplied func, so tests can simulate CancelRequested or a task-gone error on a chosen call.
+type coordinatedFakeSWFClient struct {
+	fakeSWFClient
+
+	onHeartbeat func(call int) (*swf.RecordActivityTaskHeartbeatOutput, error)
+
+	mu        sync.Mutex
+	calls     int
+	completed int
+	failed    int
+	canceled  int
+}
+
+func (f *coordinatedFakeSWFClient) RecordActivityTaskHeartbeat(in *swf.RecordActivityTaskHeartbeatInput) (*swf.RecordActivityTaskHeartbeatOutput, error) {
+	f.mu.Lock()
+	f.calls++
+	call := f.calls
+	f.mu.Unlock()
+	return f.onHeartbeat(call)
+}
+
+func (f *coordinatedFakeSWFClient) RespondActivityTaskCompleted(in *swf.RespondActivityTaskCompletedInput) (*swf.RespondActivityTaskCompletedOutput, error) {
+	f.mu.Lock()
+	f.completed++
+	f.mu.Unlock()
+	return &swf.RespondActivityTaskCompletedOutput{}, nil
+}
+
+func (f *coordinatedFakeSWFClient) RespondActivityTaskFailed(in *swf.RespondActivityTaskFailedInput) (*swf.RespondActivityTaskFailedOutput, error) {
+	f.mu.Lock()
+	f.failed++
+	f.mu.Unlock()
+	return &swf.RespondActivityTaskFailedOutput{}, nil
+}
+
+func (f *coordinatedFakeSWFClient) RespondActivityTaskCanceled(in *swf.RespondActivityTaskCanceledInput) (*swf.RespondActivityTaskCanceledOutput, error) {
+	f.mu.Lock()
+	f.canceled++
+	f.mu.Unlock()
+	return &swf.RespondActivityTaskCanceledOutput{}, nil
+}
+
+func coordinatedTestTask() *swf.PollForActivityTaskOutput {
+	return &swf.PollForActivityTaskOutput{
+		TaskToken:         S("task-token"),
+		ActivityID:        S("activity-id"),
+		ActivityType:      &swf.ActivityType{Name: S("coordinated-activity"), Version: S("1.0")},
+		WorkflowExecution: &swf.WorkflowExecution{WorkflowID: S("workflow-id"), RunID: S("run-id")},
+	}
+}
+
+func TestCoordinatedHandlerCancelRequestedRespondsCanceledNotFailed(t *testing.T) {
+	fake := &coordinatedFakeSWFClient{
+		onHeartbeat: func(call int) (*swf.RecordActivityTaskHeartbeatOutput, error) {
+			cancelRequested := true
+			return &swf.RecordActivityTaskHeartbeatOutput{CancelRequested: &cancelRequested}, nil
+		},
+	}
+	w := &ActivityWorker{SWF: fake}
+
+	cancelCalled := make(chan struct{}, 1)
+	w.AddCoordinatedHandler(5*time.Millisecond, time.Hour, &CoordinatedActivityHandler{
+		Activity: &swf.ActivityType{Name: S("coordinated-activity")},
+		Start: func(ctx context.Context, activityTask *swf.PollForActivityTaskOutput, input interface{}) (interface{}, error) {
+			return nil, nil
+		},
+		Tick: func(ctx context.Context, activityTask *swf.PollForActivityTaskOutput, input interface{}) (bool, interface{}, error) {
+			return true, nil, nil
+		},
+		Cancel: func(ctx context.Context, activityTask *swf.PollForActivityTaskOutput, input interface{}) error {
+			cancelCalled <- struct{}{}
+			return nil
+		},
+	})
+
+	done := make(chan struct{})
+	go func() {
+		w.dispatch(coordinatedTestTask())
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("dispatch did not return after heartbeat saw CancelRequested")
+	}
+
+	select {
+	case <-cancelCalled:
+	default:
+		t.Fatal("CoordinatedActivityHandler.Cancel was not called")
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if fake.canceled != 1 {
+		t.Fatalf("canceled = %d, want 1", fake.canceled)
+	}
+	if fake.failed != 0 {
+		t.Fatalf("failed = %d, want 0 (cancellation must not be reported as a failure)", fake.failed)
+	}
+	if fake.completed != 0 {
+		t.Fatalf("completed = %d, want 0", fake.completed)
+	}
+}
+
+func TestCoordinatedHandlerTaskGoneDropsResponse(t *testing.T) {
+	fake := &coordinatedFakeSWFClient{
+		onHeartbeat: func(call int) (*swf.RecordActivityTaskHeartbeatOutput, error) {
+			return nil, &fakeAWSError{code: ErrorTypeUnknownResourceFault, message: TaskGone + ": activityId=activity-id"}
+		},
+	}
+	w := &ActivityWorker{SWF: fake}
+
+	cancelCalled := make(chan struct{}, 1)
+	w.AddCoordinatedHandler(5*time.Millisecond, time.Hour, &CoordinatedActivityHandler{
+		Activity: &swf.ActivityType{Name: S("coordinated-activity")},
+		Start: func(ctx context.Context, activityTask *swf.PollForActivityTaskOutput, input interface{}) (interface{}, error) {
+			return nil, nil
+		},
+		Tick: func(ctx context.Context, activityTask *swf.PollForActivityTaskOutput, input interface{}) (bool, interface{}, error) {
+			return true, nil, nil
+		},
+		Cancel: func(ctx context.Context, activityTask *swf.PollForActivityTaskOutput, input interface{}) error {
+			cancelCalled <- struct{}{}
+			return nil
+		},
+	})
+
+	done := make(chan struct{})
+	go func() {
+		w.dispatch(coordinatedTestTask())
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("dispatch did not return after heartbeat saw a task-gone error")
+	}
+
+	select {
+	case <-cancelCalled:
+	default:
+		t.Fatal("CoordinatedActivityHandler.Cancel was not called")
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if fake.completed != 0 || fake.failed != 0 || fake.canceled != 0 {
+		t.Fatalf("completed=%d failed=%d canceled=%d, want all 0 (SWF already considers the task gone)", fake.completed, fake.failed, fake.canceled)
+	}
+}
+
+func TestAddHandlerDisablesAutoHeartbeatForNonPositiveInterval(t *testing.T) {
+	w := &ActivityWorker{SWF: &fakeSWFClient{}}
+	h := &ActivityHandler{
+		Activity:            &swf.ActivityType{Name: S("no-heartbeat-config")},
+		EnableAutoHeartbeat: true,
+		HandlerFunc: func(ctx context.Context, activityTask *swf.PollForActivityTaskOutput, input interface{}) (interface{}, error) {
+			return nil, nil
+		},
+	}
+	w.AddHandler(h)
+
+	if h.EnableAutoHeartbeat {
+		t.Fatal("AddHandler left EnableAutoHeartbeat on despite HeartbeatTimeout and HeartbeatInterval both being zero")
+	}
+
+	task := coordinatedTestTask()
+	task.ActivityType = &swf.ActivityType{Name: S("no-heartbeat-config")}
+
+	// Dispatching must not panic now that auto-heartbeat has been disabled.
+	w.dispatch(task)
+}
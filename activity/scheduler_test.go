@@ -0,0 +1,53 @@
+package activity
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestJitteredBackoffSchedulerNextWithinJitterBounds(t *testing.T) {
+	base := 10 * time.Second
+	s := NewHeartbeatScheduler(base, base*8)
+
+	for i := 0; i < 50; i++ {
+		next := s.Next()
+		if next < base*8/10 || next > base*12/10 {
+			t.Fatalf("Next() = %v, want within +/-20%% of %v", next, base)
+		}
+	}
+}
+
+func TestJitteredBackoffSchedulerOnErrorDoublesUpToCap(t *testing.T) {
+	base := 1 * time.Second
+	maxInterval := 8 * time.Second
+	s := NewHeartbeatScheduler(base, maxInterval).(*jitteredBackoffScheduler)
+
+	wantCurrent := base
+	for i := 0; i < 5; i++ {
+		s.OnError(errors.New("throttled"))
+		wantCurrent *= 2
+		if wantCurrent > maxInterval {
+			wantCurrent = maxInterval
+		}
+		if s.current != wantCurrent {
+			t.Fatalf("after %d OnError calls, current = %v, want %v", i+1, s.current, wantCurrent)
+		}
+	}
+}
+
+func TestJitteredBackoffSchedulerOnSuccessResetsToBase(t *testing.T) {
+	base := 1 * time.Second
+	s := NewHeartbeatScheduler(base, base*8).(*jitteredBackoffScheduler)
+
+	s.OnError(errors.New("throttled"))
+	s.OnError(errors.New("throttled"))
+	if s.current == base {
+		t.Fatalf("current = %v, want inflated above base after OnError calls", s.current)
+	}
+
+	s.OnSuccess()
+	if s.current != base {
+		t.Fatalf("current = %v, want reset to base %v after OnSuccess", s.current, base)
+	}
+}
@@ -0,0 +1,691 @@
+package activity
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/swf"
+	. "github.com/sclasen/swfsm/sugar"
+)
+
+// ErrorTypeUnknownResourceFault is the SWF error code returned when a task token, workflow execution,
+// or activity is no longer known to SWF.
+const ErrorTypeUnknownResourceFault = "UnknownResourceFault"
+
+// ActivityTaskCanceledError is returned from, or delivered to, a running activity when SWF reports that
+// a cancellation has been requested for the task.
+type ActivityTaskCanceledError struct{}
+
+func (a ActivityTaskCanceledError) Error() string {
+	return "activity-task-canceled"
+}
+
+// ActivityHandler associates an swf.ActivityType with the func that handles it, and the type used to
+// unmarshal the activity's input.
+type ActivityHandler struct {
+	Activity *swf.ActivityType
+	Input    interface{}
+
+	// HandlerFunc does the work of the activity. ctx is created by the worker for each task and is
+	// cancelled when SWF reports CancelRequested on a heartbeat, when StartToCloseTimeout locally
+	// elapses, or when the worker begins shutting down (see WorkerStopChannel). Well-behaved handlers
+	// should select on ctx.Done() around blocking calls and return ctx.Err() promptly when it fires.
+	HandlerFunc func(ctx context.Context, activityTask *swf.PollForActivityTaskOutput, input interface{}) (interface{}, error)
+
+	// EnableAutoHeartbeat, when true, makes the worker record heartbeats on this handler's behalf for
+	// as long as HandlerFunc is running, so a plain func(ctx, input) (result, error) activity can
+	// survive HeartbeatTimeout without being rewritten as a CoordinatedActivityHandler.
+	EnableAutoHeartbeat bool
+
+	// HeartbeatTimeout is the HeartbeatTimeout configured for this activity type in SWF. When
+	// EnableAutoHeartbeat is set and HeartbeatInterval is zero, heartbeats are sent at roughly
+	// HeartbeatTimeout/2.
+	HeartbeatTimeout time.Duration
+
+	// HeartbeatInterval overrides the interval at which auto-heartbeats are sent. Optional.
+	HeartbeatInterval time.Duration
+
+	// StartToCloseTimeout is the activity's StartToCloseTimeout. When set, the ctx passed to
+	// HandlerFunc is cancelled once this much time has elapsed since dispatch began, mirroring the
+	// timeout SWF itself will enforce.
+	StartToCloseTimeout time.Duration
+}
+
+// maxHeartbeatDetailsBytes is SWF's limit on the size of the Details field of a
+// RecordActivityTaskHeartbeat call.
+const maxHeartbeatDetailsBytes = 32 * 1024
+
+// StateSerializer (de)serializes values the worker needs to put on the wire, such as RecordProgress
+// details. The zero-value ActivityWorker uses a JSON implementation; set ActivityWorker.Serializer to
+// use something else.
+type StateSerializer interface {
+	Serialize(interface{}) (string, error)
+	Deserialize(string, interface{}) error
+}
+
+type jsonStateSerializer struct{}
+
+func (jsonStateSerializer) Serialize(v interface{}) (string, error) {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+func (jsonStateSerializer) Deserialize(s string, v interface{}) error {
+	return json.Unmarshal([]byte(s), v)
+}
+
+func (w *ActivityWorker) serializer() StateSerializer {
+	if w.Serializer != nil {
+		return w.Serializer
+	}
+	return jsonStateSerializer{}
+}
+
+type progressRecorderKey struct{}
+
+// ProgressReporter lets a running activity attach Details to the next heartbeat SWF sees for it. The
+// worker supplies one on the ctx passed to HandlerFunc; handlers use it by calling RecordProgress(ctx, ...).
+type ProgressReporter struct {
+	worker *ActivityWorker
+
+	mu      sync.Mutex
+	details string
+}
+
+// RecordProgress serializes details with the worker's Serializer and attaches them to the next
+// RecordActivityTaskHeartbeat call made for the activity task associated with ctx. Calling it again
+// before the next heartbeat replaces the previous details rather than queuing them. It returns an error
+// if ctx didn't come from an ActivityWorker, or if the serialized details exceed SWF's 32KiB heartbeat
+// details limit.
+func RecordProgress(ctx context.Context, details interface{}) error {
+	r, ok := ctx.Value(progressRecorderKey{}).(*ProgressReporter)
+	if !ok {
+		return fmt.Errorf("activity: RecordProgress called with a ctx not supplied by ActivityWorker")
+	}
+	return r.record(details)
+}
+
+func (r *ProgressReporter) record(details interface{}) error {
+	encoded, err := r.worker.serializer().Serialize(details)
+	if err != nil {
+		return fmt.Errorf("serialize-progress-details: %s", err)
+	}
+	if len(encoded) > maxHeartbeatDetailsBytes {
+		return fmt.Errorf("progress details are %d bytes, which exceeds the %d byte SWF heartbeat details limit", len(encoded), maxHeartbeatDetailsBytes)
+	}
+	r.mu.Lock()
+	r.details = encoded
+	r.mu.Unlock()
+	return nil
+}
+
+// takeDetails returns the latest recorded details, or nil if none have been recorded yet, for inclusion
+// on a RecordActivityTaskHeartbeatInput. The details are kept, so every later heartbeat repeats them
+// until RecordProgress replaces them.
+func (r *ProgressReporter) takeDetails() *string {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.details == "" {
+		return nil
+	}
+	return S(r.details)
+}
+
+func progressReporterFrom(ctx context.Context) *ProgressReporter {
+	r, _ := ctx.Value(progressRecorderKey{}).(*ProgressReporter)
+	return r
+}
+
+// CoordinatedActivityHandler is an activity handler that runs as a series of short Tick calls rather
+// than a single blocking call, so that AddCoordinatedHandler can interleave heartbeats, cancellation,
+// and workflow signaling around it.
+type CoordinatedActivityHandler struct {
+	Activity *swf.ActivityType
+	Input    interface{}
+
+	// Start is called once, before the first Tick, and returns an update to signal back to the
+	// workflow that the activity has begun. ctx carries the task's ProgressReporter, so Start may call
+	// RecordProgress(ctx, ...).
+	Start func(ctx context.Context, activityTask *swf.PollForActivityTaskOutput, input interface{}) (interface{}, error)
+
+	// Tick is called repeatedly, at most once per tickMinInterval, until it returns cont == false.
+	// A non-nil res is signaled back to the workflow as a progress update. ctx carries the task's
+	// ProgressReporter, so Tick may call RecordProgress(ctx, ...).
+	Tick func(ctx context.Context, activityTask *swf.PollForActivityTaskOutput, input interface{}) (cont bool, res interface{}, err error)
+
+	// Cancel is called when the task's ctx is cancelled (SWF reports cancellation requested or the task
+	// gone, StartToCloseTimeout elapses, or the worker begins shutting down), and when signaling a Tick
+	// update back to the workflow fails.
+	Cancel func(ctx context.Context, activityTask *swf.PollForActivityTaskOutput, input interface{}) error
+
+	// Scheduler, if set, is called once per task to build the HeartbeatScheduler that paces
+	// heartbeats for that task's run. A CoordinatedActivityHandler is registered once per activity
+	// type and its coordinatedActivityAdapter is reused across every concurrent invocation of that
+	// type, so Scheduler must return a fresh HeartbeatScheduler each call: returning the same shared
+	// instance lets one task's OnSuccess/OnError reset or inflate another, unrelated task's backoff.
+	// Defaults to a jittered, backed-off HeartbeatScheduler built from the heartbeatInterval passed to
+	// AddCoordinatedHandler.
+	Scheduler func() HeartbeatScheduler
+}
+
+// SWFClient is the subset of *swf.SWF that ActivityWorker depends on. Tests can substitute a fake.
+type SWFClient interface {
+	PollForActivityTask(*swf.PollForActivityTaskInput) (*swf.PollForActivityTaskOutput, error)
+	RecordActivityTaskHeartbeat(*swf.RecordActivityTaskHeartbeatInput) (*swf.RecordActivityTaskHeartbeatOutput, error)
+	RespondActivityTaskCompleted(*swf.RespondActivityTaskCompletedInput) (*swf.RespondActivityTaskCompletedOutput, error)
+	RespondActivityTaskFailed(*swf.RespondActivityTaskFailedInput) (*swf.RespondActivityTaskFailedOutput, error)
+	RespondActivityTaskCanceled(*swf.RespondActivityTaskCanceledInput) (*swf.RespondActivityTaskCanceledOutput, error)
+	SignalWorkflowExecution(*swf.SignalWorkflowExecutionInput) (*swf.SignalWorkflowExecutionOutput, error)
+}
+
+// ActivityWorker polls a task list for activity tasks and dispatches them to registered ActivityHandlers.
+type ActivityWorker struct {
+	Domain   string
+	TaskList string
+	Identity string
+	SWF      SWFClient
+
+	// Serializer (de)serializes RecordProgress details. Defaults to JSON.
+	Serializer StateSerializer
+
+	handlersLock sync.Mutex
+	handlers     map[string]*ActivityHandler
+
+	stopOnce     sync.Once
+	stopCh       chan struct{}
+	shutdownOnce sync.Once
+
+	wg           sync.WaitGroup
+	inFlightLock sync.Mutex
+	inFlight     map[string]struct{}
+}
+
+// WorkerStopChannel returns a channel that is closed once the worker begins shutting down. Handlers
+// running with a plumbed context should prefer ctx.Done(), but may select on this directly to
+// distinguish "SWF cancelled this task" from "the worker is shutting down" without swallowing the
+// task token.
+func (w *ActivityWorker) WorkerStopChannel() <-chan struct{} {
+	w.stopOnce.Do(func() {
+		w.stopCh = make(chan struct{})
+	})
+	return w.stopCh
+}
+
+// Shutdown stops polling for new activity tasks, signals WorkerStopChannel so every running handler's
+// ctx is cancelled, and waits for in-flight handlers to return. If ctx is done before they all return,
+// Shutdown sends one last heartbeat for each still-outstanding task (best effort, errors are ignored)
+// and returns a *ShutdownTimeoutError listing their task tokens so the caller can surface them for
+// retry.
+func (w *ActivityWorker) Shutdown(ctx context.Context) error {
+	w.shutdownOnce.Do(func() {
+		w.WorkerStopChannel()
+		close(w.stopCh)
+	})
+
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		tokens := w.inFlightTokens()
+		for _, token := range tokens {
+			w.SWF.RecordActivityTaskHeartbeat(&swf.RecordActivityTaskHeartbeatInput{TaskToken: S(token)})
+		}
+		return &ShutdownTimeoutError{Err: ctx.Err(), OutstandingTasks: tokens}
+	}
+}
+
+// ShutdownTimeoutError is returned by ActivityWorker.Shutdown when its ctx is done before all in-flight
+// activities finished.
+type ShutdownTimeoutError struct {
+	Err              error
+	OutstandingTasks []string
+}
+
+func (e *ShutdownTimeoutError) Error() string {
+	return fmt.Sprintf("activity: shutdown timed out with %d task(s) still outstanding: %s (%s)", len(e.OutstandingTasks), strings.Join(e.OutstandingTasks, ", "), e.Err)
+}
+
+func (e *ShutdownTimeoutError) Unwrap() error {
+	return e.Err
+}
+
+func (w *ActivityWorker) trackInFlight(taskToken string) {
+	w.inFlightLock.Lock()
+	defer w.inFlightLock.Unlock()
+	if w.inFlight == nil {
+		w.inFlight = make(map[string]struct{})
+	}
+	w.inFlight[taskToken] = struct{}{}
+}
+
+func (w *ActivityWorker) untrackInFlight(taskToken string) {
+	w.inFlightLock.Lock()
+	defer w.inFlightLock.Unlock()
+	delete(w.inFlight, taskToken)
+}
+
+func (w *ActivityWorker) inFlightTokens() []string {
+	w.inFlightLock.Lock()
+	defer w.inFlightLock.Unlock()
+	tokens := make([]string, 0, len(w.inFlight))
+	for token := range w.inFlight {
+		tokens = append(tokens, token)
+	}
+	return tokens
+}
+
+// AddHandler registers h for its Activity. Registering a second handler for the same activity type
+// replaces the first.
+func (w *ActivityWorker) AddHandler(h *ActivityHandler) {
+	w.handlersLock.Lock()
+	defer w.handlersLock.Unlock()
+	if w.handlers == nil {
+		w.handlers = make(map[string]*ActivityHandler)
+	}
+	if h.EnableAutoHeartbeat && autoHeartbeatInterval(h) <= 0 {
+		log.Printf("activity-type=%s at=auto-heartbeat-disabled reason=non-positive-heartbeat-interval heartbeat-timeout=%s heartbeat-interval=%s", LS(h.Activity.Name), h.HeartbeatTimeout, h.HeartbeatInterval)
+		h.EnableAutoHeartbeat = false
+	}
+	w.handlers[LS(h.Activity.Name)] = h
+}
+
+func (w *ActivityWorker) handler(activityType string) (*ActivityHandler, bool) {
+	w.handlersLock.Lock()
+	defer w.handlersLock.Unlock()
+	h, ok := w.handlers[activityType]
+	return h, ok
+}
+
+// Start polls Domain/TaskList for activity tasks until the worker begins shutting down (see Shutdown),
+// dispatching each task to its registered handler on its own goroutine.
+func (w *ActivityWorker) Start() {
+	for {
+		select {
+		case <-w.WorkerStopChannel():
+			return
+		default:
+		}
+		task, err := w.SWF.PollForActivityTask(&swf.PollForActivityTaskInput{
+			Domain:   S(w.Domain),
+			TaskList: &swf.TaskList{Name: S(w.TaskList)},
+			Identity: S(w.Identity),
+		})
+		if err != nil {
+			log.Printf("at=poll-for-activity-task-error error=%s", err.Error())
+			continue
+		}
+		if task.TaskToken == nil {
+			continue
+		}
+		select {
+		case <-w.WorkerStopChannel():
+			// The worker started shutting down while PollForActivityTask was in flight. Leave this
+			// task undispatched (and therefore untracked by Shutdown's WaitGroup) rather than race
+			// wg.Add against a wg.Wait that may already have returned; SWF will time it out and apply
+			// the activity's retry policy same as any other task dropped during shutdown.
+			log.Printf("workflow-id=%s activity-id=%s at=dispatch-worker-stopping-drop-poll-result", LS(task.WorkflowExecution.WorkflowID), LS(task.ActivityID))
+			return
+		default:
+		}
+		go w.dispatch(task)
+	}
+}
+
+func (w *ActivityWorker) dispatch(task *swf.PollForActivityTaskOutput) {
+	w.wg.Add(1)
+	defer w.wg.Done()
+
+	taskToken := LS(task.TaskToken)
+	w.trackInFlight(taskToken)
+	defer w.untrackInFlight(taskToken)
+
+	handler, ok := w.handler(LS(task.ActivityType.Name))
+	if !ok {
+		log.Printf("workflow-id=%s activity-id=%s at=no-handler-registered activity-type=%s", LS(task.WorkflowExecution.WorkflowID), LS(task.ActivityID), LS(task.ActivityType.Name))
+		return
+	}
+
+	input, err := handler.unmarshalInput(task)
+	if err != nil {
+		w.fail(task, err)
+		return
+	}
+
+	d := newDispatchCancel()
+	defer d.cancelWith("")
+
+	if handler.StartToCloseTimeout > 0 {
+		timer := time.AfterFunc(handler.StartToCloseTimeout, func() { d.cancelWith(cancelReasonStartToCloseTimeout) })
+		defer timer.Stop()
+	}
+
+	go func() {
+		select {
+		case <-w.WorkerStopChannel():
+			d.cancelWith(cancelReasonWorkerStopping)
+		case <-d.ctx.Done():
+		}
+	}()
+
+	progress := &ProgressReporter{worker: w}
+	ctx := context.WithValue(d.ctx, progressRecorderKey{}, progress)
+	ctx = context.WithValue(ctx, dispatchCancelKey{}, d)
+
+	var heartbeat *autoHeartbeat
+	if handler.EnableAutoHeartbeat {
+		heartbeat = w.startAutoHeartbeat(task, handler, d, progress)
+		defer heartbeat.stop()
+	}
+
+	result, err := handler.HandlerFunc(ctx, task, input)
+
+	if heartbeat != nil && heartbeat.isGone() {
+		log.Printf("workflow-id=%s activity-id=%s at=auto-heartbeat-task-gone-drop-response", LS(task.WorkflowExecution.WorkflowID), LS(task.ActivityID))
+		return
+	}
+
+	switch d.reason() {
+	case cancelReasonWorkerStopping:
+		log.Printf("workflow-id=%s activity-id=%s at=dispatch-worker-stopping-drop-response", LS(task.WorkflowExecution.WorkflowID), LS(task.ActivityID))
+		return
+	case cancelReasonTaskGone:
+		// Only reachable via a CoordinatedActivityHandler; a plain handler's autoHeartbeat is caught
+		// by the isGone() check above before this switch runs.
+		log.Printf("workflow-id=%s activity-id=%s at=dispatch-task-gone-drop-response", LS(task.WorkflowExecution.WorkflowID), LS(task.ActivityID))
+		return
+	case cancelReasonCancelRequested:
+		w.cancel(task, "")
+		return
+	}
+
+	if err != nil {
+		w.fail(task, err)
+		return
+	}
+	w.complete(task, result)
+}
+
+const (
+	cancelReasonCancelRequested     = "cancel-requested"
+	cancelReasonTaskGone            = "task-gone"
+	cancelReasonStartToCloseTimeout = "start-to-close-timeout"
+	cancelReasonWorkerStopping      = "worker-stopping"
+)
+
+// dispatchCancel derives the ctx passed to an ActivityHandler's HandlerFunc, and records why it was
+// cancelled so dispatch can decide how to respond to SWF once the handler returns.
+type dispatchCancel struct {
+	ctx       context.Context
+	cancelCtx context.CancelFunc
+
+	mu           sync.Mutex
+	cancelReason string
+}
+
+func newDispatchCancel() *dispatchCancel {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &dispatchCancel{ctx: ctx, cancelCtx: cancel}
+}
+
+// cancelWith cancels the ctx, recording reason as the cause if nothing has cancelled it yet. An empty
+// reason just cancels the ctx, e.g. on ordinary dispatch-function exit.
+func (d *dispatchCancel) cancelWith(reason string) {
+	d.mu.Lock()
+	if reason != "" && d.cancelReason == "" {
+		d.cancelReason = reason
+	}
+	d.mu.Unlock()
+	d.cancelCtx()
+}
+
+func (d *dispatchCancel) reason() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cancelReason
+}
+
+type dispatchCancelKey struct{}
+
+// dispatchCancelFrom returns the dispatchCancel backing ctx, so a HandlerFunc running out-of-band
+// heartbeats of its own (e.g. coordinatedActivityAdapter.heartbeat) can record a cancellation reason
+// the same way dispatch's own auto-heartbeat and timeout paths do, instead of inventing a parallel
+// signaling mechanism dispatch doesn't know how to classify.
+func dispatchCancelFrom(ctx context.Context) *dispatchCancel {
+	d, _ := ctx.Value(dispatchCancelKey{}).(*dispatchCancel)
+	return d
+}
+
+func (h *ActivityHandler) unmarshalInput(task *swf.PollForActivityTaskOutput) (interface{}, error) {
+	if h.Input == nil || task.Input == nil {
+		return nil, nil
+	}
+	t := reflect.TypeOf(h.Input)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	input := reflect.New(t).Interface()
+	if err := json.Unmarshal([]byte(LS(task.Input)), input); err != nil {
+		return nil, fmt.Errorf("unmarshal-activity-input: %s", err)
+	}
+	return input, nil
+}
+
+func (w *ActivityWorker) complete(task *swf.PollForActivityTaskOutput, result interface{}) {
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		w.fail(task, fmt.Errorf("marshal-activity-result: %s", err))
+		return
+	}
+	if _, err := w.SWF.RespondActivityTaskCompleted(&swf.RespondActivityTaskCompletedInput{
+		TaskToken: task.TaskToken,
+		Result:    S(string(encoded)),
+	}); err != nil {
+		log.Printf("workflow-id=%s activity-id=%s at=respond-activity-task-completed-error error=%s", LS(task.WorkflowExecution.WorkflowID), LS(task.ActivityID), err.Error())
+	}
+}
+
+func (w *ActivityWorker) fail(task *swf.PollForActivityTaskOutput, taskErr error) {
+	if _, err := w.SWF.RespondActivityTaskFailed(&swf.RespondActivityTaskFailedInput{
+		TaskToken: task.TaskToken,
+		Reason:    S(taskErr.Error()),
+	}); err != nil {
+		log.Printf("workflow-id=%s activity-id=%s at=respond-activity-task-failed-error error=%s", LS(task.WorkflowExecution.WorkflowID), LS(task.ActivityID), err.Error())
+	}
+}
+
+func (w *ActivityWorker) cancel(task *swf.PollForActivityTaskOutput, details string) {
+	input := &swf.RespondActivityTaskCanceledInput{TaskToken: task.TaskToken}
+	if details != "" {
+		input.Details = S(details)
+	}
+	if _, err := w.SWF.RespondActivityTaskCanceled(input); err != nil {
+		log.Printf("workflow-id=%s activity-id=%s at=respond-activity-task-canceled-error error=%s", LS(task.WorkflowExecution.WorkflowID), LS(task.ActivityID), err.Error())
+	}
+}
+
+const (
+	signalNameActivityStarted = "activity-started"
+	signalNameActivityUpdated = "activity-updated"
+)
+
+func (w *ActivityWorker) signal(task *swf.PollForActivityTaskOutput, signalName string, data interface{}) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = w.SWF.SignalWorkflowExecution(&swf.SignalWorkflowExecutionInput{
+		Domain:     S(w.Domain),
+		WorkflowID: task.WorkflowExecution.WorkflowID,
+		RunID:      task.WorkflowExecution.RunID,
+		SignalName: S(signalName),
+		Input:      S(string(encoded)),
+	})
+	return err
+}
+
+func (w *ActivityWorker) signalStart(task *swf.PollForActivityTaskOutput, update interface{}) error {
+	return w.signal(task, signalNameActivityStarted, update)
+}
+
+func (w *ActivityWorker) signalUpdate(task *swf.PollForActivityTaskOutput, update interface{}) error {
+	return w.signal(task, signalNameActivityUpdated, update)
+}
+
+// autoHeartbeat runs in the background for the lifetime of a plain ActivityHandler whose
+// EnableAutoHeartbeat is set, recording heartbeats so the handler doesn't have to. It cancels the
+// handler's ctx (via d) as soon as SWF reports the task gone or cancellation requested.
+type autoHeartbeat struct {
+	stopCh chan struct{}
+
+	mu   sync.Mutex
+	gone bool
+}
+
+// autoHeartbeatInterval computes the interval startAutoHeartbeat will tick at: HeartbeatInterval if
+// set, otherwise half of HeartbeatTimeout. The result can be non-positive if a caller enables
+// auto-heartbeat without setting either field; AddHandler checks this and disables auto-heartbeat
+// rather than let startAutoHeartbeat hand a non-positive duration to time.NewTicker, which panics.
+func autoHeartbeatInterval(h *ActivityHandler) time.Duration {
+	if h.HeartbeatInterval != 0 {
+		return h.HeartbeatInterval
+	}
+	return h.HeartbeatTimeout / 2
+}
+
+func (w *ActivityWorker) startAutoHeartbeat(task *swf.PollForActivityTaskOutput, handler *ActivityHandler, d *dispatchCancel, progress *ProgressReporter) *autoHeartbeat {
+	interval := autoHeartbeatInterval(handler)
+
+	h := &autoHeartbeat{stopCh: make(chan struct{})}
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				status, err := w.SWF.RecordActivityTaskHeartbeat(&swf.RecordActivityTaskHeartbeatInput{
+					TaskToken: task.TaskToken,
+					Details:   progress.takeDetails(),
+				})
+				if err != nil {
+					if ae, ok := err.(awserr.Error); ok && ae.Code() == ErrorTypeUnknownResourceFault && containsTaskGone(ae.Message()) {
+						log.Printf("workflow-id=%s activity-id=%s at=auto-heartbeat-task-gone", LS(task.WorkflowExecution.WorkflowID), LS(task.ActivityID))
+						h.mu.Lock()
+						h.gone = true
+						h.mu.Unlock()
+						d.cancelWith(cancelReasonTaskGone)
+						return
+					}
+					log.Printf("workflow-id=%s activity-id=%s at=auto-heartbeat-error error=%s", LS(task.WorkflowExecution.WorkflowID), LS(task.ActivityID), err.Error())
+					continue
+				}
+				log.Printf("workflow-id=%s activity-id=%s at=auto-heartbeat-recorded", LS(task.WorkflowExecution.WorkflowID), LS(task.ActivityID))
+				if status.CancelRequested != nil && *status.CancelRequested {
+					log.Printf("workflow-id=%s activity-id=%s at=auto-heartbeat-cancel-requested", LS(task.WorkflowExecution.WorkflowID), LS(task.ActivityID))
+					d.cancelWith(cancelReasonCancelRequested)
+				}
+			case <-h.stopCh:
+				return
+			}
+		}
+	}()
+
+	return h
+}
+
+func (h *autoHeartbeat) stop() {
+	close(h.stopCh)
+}
+
+func (h *autoHeartbeat) isGone() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.gone
+}
+
+func containsTaskGone(message string) bool {
+	return strings.Contains(message, TaskGone)
+}
+
+// HeartbeatScheduler controls the pacing of RecordActivityTaskHeartbeat calls for a
+// CoordinatedActivityHandler. Set CoordinatedActivityHandler.Scheduler to wire in a custom
+// implementation, e.g. one backed by your own rate-limiter.
+type HeartbeatScheduler interface {
+	// Next returns how long to wait before the next heartbeat attempt.
+	Next() time.Duration
+	// OnSuccess is called after a heartbeat attempt completes without error.
+	OnSuccess()
+	// OnError is called after a heartbeat attempt fails with a transient error, e.g. throttling or a
+	// 5xx from SWF.
+	OnError(err error)
+}
+
+// jitteredBackoffScheduler is the default HeartbeatScheduler. It adds +/-20% jitter to base so that
+// many coordinated activities started around the same time (e.g. right after a worker restart) don't
+// all heartbeat in lockstep, and backs off exponentially, doubling up to maxInterval, on consecutive
+// errors, resetting to base as soon as a heartbeat succeeds again.
+type jitteredBackoffScheduler struct {
+	base        time.Duration
+	maxInterval time.Duration
+
+	mu      sync.Mutex
+	current time.Duration
+}
+
+// NewHeartbeatScheduler returns the default HeartbeatScheduler: base jittered by +/-20%, backing off
+// exponentially up to maxInterval on consecutive errors.
+func NewHeartbeatScheduler(base, maxInterval time.Duration) HeartbeatScheduler {
+	return &jitteredBackoffScheduler{base: base, maxInterval: maxInterval, current: base}
+}
+
+func (s *jitteredBackoffScheduler) Next() time.Duration {
+	s.mu.Lock()
+	current := s.current
+	s.mu.Unlock()
+	return jitter(current)
+}
+
+func (s *jitteredBackoffScheduler) OnSuccess() {
+	s.mu.Lock()
+	s.current = s.base
+	s.mu.Unlock()
+}
+
+func (s *jitteredBackoffScheduler) OnError(err error) {
+	s.mu.Lock()
+	next := s.current * 2
+	if next > s.maxInterval {
+		next = s.maxInterval
+	}
+	s.current = next
+	s.mu.Unlock()
+}
+
+// jitter returns d +/- up to 20%, at random.
+func jitter(d time.Duration) time.Duration {
+	delta := time.Duration(float64(d) * 0.2)
+	if delta <= 0 {
+		return d
+	}
+	return d - delta + time.Duration(rand.Int63n(int64(2*delta+1)))
+}
@@ -0,0 +1,105 @@
+package activity
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/swf"
+	. "github.com/sclasen/swfsm/sugar"
+)
+
+type fakeSWFClient struct {
+	heartbeats []string
+}
+
+func (f *fakeSWFClient) PollForActivityTask(*swf.PollForActivityTaskInput) (*swf.PollForActivityTaskOutput, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (f *fakeSWFClient) RecordActivityTaskHeartbeat(in *swf.RecordActivityTaskHeartbeatInput) (*swf.RecordActivityTaskHeartbeatOutput, error) {
+	f.heartbeats = append(f.heartbeats, LS(in.TaskToken))
+	cancelRequested := false
+	return &swf.RecordActivityTaskHeartbeatOutput{CancelRequested: &cancelRequested}, nil
+}
+
+func (f *fakeSWFClient) RespondActivityTaskCompleted(*swf.RespondActivityTaskCompletedInput) (*swf.RespondActivityTaskCompletedOutput, error) {
+	return &swf.RespondActivityTaskCompletedOutput{}, nil
+}
+
+func (f *fakeSWFClient) RespondActivityTaskFailed(*swf.RespondActivityTaskFailedInput) (*swf.RespondActivityTaskFailedOutput, error) {
+	return &swf.RespondActivityTaskFailedOutput{}, nil
+}
+
+func (f *fakeSWFClient) RespondActivityTaskCanceled(*swf.RespondActivityTaskCanceledInput) (*swf.RespondActivityTaskCanceledOutput, error) {
+	return &swf.RespondActivityTaskCanceledOutput{}, nil
+}
+
+func (f *fakeSWFClient) SignalWorkflowExecution(*swf.SignalWorkflowExecutionInput) (*swf.SignalWorkflowExecutionOutput, error) {
+	return &swf.SignalWorkflowExecutionOutput{}, nil
+}
+
+func TestActivityWorkerShutdownDrainsCleanly(t *testing.T) {
+	w := &ActivityWorker{SWF: &fakeSWFClient{}}
+
+	w.wg.Add(1)
+	w.trackInFlight("token-1")
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		w.untrackInFlight("token-1")
+		w.wg.Done()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := w.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown returned %v, want nil", err)
+	}
+}
+
+func TestActivityWorkerShutdownTimesOutWithOutstandingTasks(t *testing.T) {
+	fake := &fakeSWFClient{}
+	w := &ActivityWorker{SWF: fake}
+
+	w.wg.Add(1)
+	w.trackInFlight("token-stuck")
+	defer w.wg.Done() // unblock the background wg.Wait goroutine once the test is done
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err := w.Shutdown(ctx)
+	if err == nil {
+		t.Fatal("Shutdown returned nil error, want a *ShutdownTimeoutError")
+	}
+
+	var timeoutErr *ShutdownTimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("Shutdown returned %T, want *ShutdownTimeoutError", err)
+	}
+	if len(timeoutErr.OutstandingTasks) != 1 || timeoutErr.OutstandingTasks[0] != "token-stuck" {
+		t.Fatalf("OutstandingTasks = %v, want [token-stuck]", timeoutErr.OutstandingTasks)
+	}
+	if len(fake.heartbeats) != 1 || fake.heartbeats[0] != "token-stuck" {
+		t.Fatalf("expected one final heartbeat for token-stuck, got %v", fake.heartbeats)
+	}
+}
+
+func TestActivityWorkerWorkerStopChannelClosedOnShutdown(t *testing.T) {
+	w := &ActivityWorker{SWF: &fakeSWFClient{}}
+	stop := w.WorkerStopChannel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := w.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown returned %v, want nil", err)
+	}
+
+	select {
+	case <-stop:
+	default:
+		t.Fatal("WorkerStopChannel was not closed by Shutdown")
+	}
+}